@@ -0,0 +1,149 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// backendPeekLen is how many leading bytes of a stream are shown to each
+// Backend's Match.
+const backendPeekLen = 16
+
+// Backend is a pluggable tag-reading backend. Built-in backends cover the
+// formats this package already reads (FLAC, DSF, ID3v2 and MP4); third
+// parties can implement Backend for formats it doesn't support (Opus,
+// WavPack, Musepack, ...) and add them with Register, without forking this
+// package. ReadFrom tries Match on the most recently registered backend
+// first, so a backend registered later can take priority over a built-in
+// one by running its own init() after this package's.
+type Backend interface {
+	// Name identifies the backend, e.g. for error messages and logging.
+	Name() string
+
+	// Match reports whether this backend can read a stream beginning
+	// with head, the first backendPeekLen bytes (fewer, at end of file).
+	Match(head []byte) bool
+
+	// Read parses metadata from r, which ReadFrom positions at the start
+	// of the stream before calling Read.
+	Read(r io.ReadSeeker) (Metadata, error)
+}
+
+var backends []Backend
+
+// Register adds b to the set of backends ReadFrom tries, ahead of every
+// backend registered before it. Built-in backends for FLAC, DSF, ID3v2 and
+// MP4 are registered from this package's init();
+// call Register from your own init() to add a backend for another
+// format, or to substitute one for a format this package already reads
+// (e.g. a cgo taglib backend, or an in-memory mock for tests).
+func Register(b Backend) {
+	backends = append([]Backend{b}, backends...)
+}
+
+// ReadFrom reads metadata from r using the most recently registered
+// Backend whose Match accepts the stream's leading bytes, restoring r to the start of
+// the stream first.
+//
+// A leading ID3v2 tag (common on FLAC files produced by rippers and
+// converters, see ReadFLACTags) hides the real signature from a plain
+// peek at the first backendPeekLen bytes, so if r starts with an ID3v2
+// tag, Match is tried again against the bytes just past it before
+// falling back to matching on the tag itself (e.g. for a plain MP3).
+func ReadFrom(r io.ReadSeeker) (Metadata, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head, err := readBytes(r, backendPeekLen)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := skipID3v2Header(r); err == nil {
+			if afterID3, err := readBytes(r, backendPeekLen); err == nil {
+				for _, b := range backends {
+					if b.Match(afterID3) {
+						if _, err := r.Seek(0, io.SeekStart); err != nil {
+							return nil, err
+						}
+						return b.Read(r)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	for _, b := range backends {
+		if b.Match(head) {
+			return b.Read(r)
+		}
+	}
+	return nil, errors.New("tag: no registered backend recognised the file")
+}
+
+func init() {
+	Register(flacBackend{})
+	Register(dsfBackend{})
+	Register(id3Backend{})
+	Register(mp4Backend{})
+}
+
+type flacBackend struct{}
+
+func (flacBackend) Name() string { return "flac" }
+
+func (flacBackend) Match(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("fLaC"))
+}
+
+func (flacBackend) Read(r io.ReadSeeker) (Metadata, error) {
+	return ReadFLACTags(r)
+}
+
+type dsfBackend struct{}
+
+func (dsfBackend) Name() string { return "dsf" }
+
+func (dsfBackend) Match(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("DSD "))
+}
+
+func (dsfBackend) Read(r io.ReadSeeker) (Metadata, error) {
+	return ReadDSFTags(r)
+}
+
+type id3Backend struct{}
+
+func (id3Backend) Name() string { return "id3" }
+
+func (id3Backend) Match(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("ID3"))
+}
+
+func (id3Backend) Read(r io.ReadSeeker) (Metadata, error) {
+	return ReadID3v2Tags(r)
+}
+
+type mp4Backend struct{}
+
+func (mp4Backend) Name() string { return "mp4" }
+
+func (mp4Backend) Match(head []byte) bool {
+	return len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp"))
+}
+
+func (mp4Backend) Read(r io.ReadSeeker) (Metadata, error) {
+	return ReadAtoms(r)
+}
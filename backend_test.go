@@ -0,0 +1,54 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBackendMatch(t *testing.T) {
+	cases := []struct {
+		name string
+		b    Backend
+		head []byte
+		want bool
+	}{
+		{"flac/match", flacBackend{}, []byte("fLaC...........\x00"), true},
+		{"flac/no-match", flacBackend{}, []byte("ID3.............\x00"), false},
+		{"dsf/match", dsfBackend{}, []byte("DSD ............\x00"), true},
+		{"id3/match", id3Backend{}, []byte("ID3.............\x00"), true},
+		{"id3/no-match", id3Backend{}, []byte("fLaC............\x00"), false},
+		{"mp4/match", mp4Backend{}, []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x02\x00"), true},
+		{"mp4/no-match", mp4Backend{}, []byte("fLaC............\x00"), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.b.Match(c.head); got != c.want {
+				t.Errorf("%s.Match(%q) = %v, want %v", c.b.Name(), c.head, got, c.want)
+			}
+		})
+	}
+}
+
+func TestReadFromDispatchesID3PrefixedFLACToFLACBackend(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("ID3")
+	buf.Write([]byte{4, 0, 0})
+	buf.Write([]byte{0, 0, 0, 10})
+	buf.Write(make([]byte, 10))
+	buf.Write(buildTestFLACFile(map[string]string{"title": "via ReadFrom"}))
+
+	m, err := ReadFrom(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if m.FileType() != FLAC {
+		t.Fatalf("FileType() = %v, want %v", m.FileType(), FLAC)
+	}
+	if got := m.Title(); got != "via ReadFrom" {
+		t.Errorf("Title() = %q, want %q", got, "via ReadFrom")
+	}
+}
@@ -5,8 +5,10 @@
 package tag
 
 import (
+	"bytes"
 	"errors"
 	"io"
+	"strings"
 )
 
 // blockType is a type which represents an enumeration of valid FLAC blocks
@@ -14,8 +16,8 @@ type blockType byte
 
 // FLAC block types.
 const (
-	streamInfoBlock    blockType = 0
-	// Padding Block               1
+	streamInfoBlock blockType = 0
+	paddingBlock    blockType = 1
 	// Application Block           2
 	// Seektable Block             3
 	// Cue Sheet Block             5
@@ -23,9 +25,22 @@ const (
 	pictureBlock       blockType = 6
 )
 
+// freshPaddingLen is the size of the Padding block written when a file's
+// existing metadata region is too small to hold the new tags in place.
+const freshPaddingLen = 8 * 1024
+
 // ReadFLACTags reads FLAC metadata from the io.ReadSeeker, returning the resulting
 // metadata in a Metadata implementation, or non-nil error if there was a problem.
 func ReadFLACTags(r io.ReadSeeker) (Metadata, error) {
+	if err := skipID3v2Header(r); err != nil {
+		return nil, err
+	}
+
+	flacStart, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
 	flac, err := readString(r, 4)
 	if err != nil {
 		return nil, err
@@ -35,7 +50,7 @@ func ReadFLACTags(r io.ReadSeeker) (Metadata, error) {
 	}
 
 	m := &metadataFLAC{
-		newMetadataVorbis(), nil,
+		metadataVorbis: newMetadataVorbis(),
 	}
 
 	for {
@@ -48,13 +63,91 @@ func ReadFLACTags(r io.ReadSeeker) (Metadata, error) {
 			break
 		}
 	}
+
+	audioStart, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	m.blocksStart = flacStart + 4
+	m.metaRegionLen = audioStart - m.blocksStart
+
 	return m, nil
 }
 
+// skipID3v2Header seeks r past a leading ID3v2 tag, if present, leaving r
+// positioned at the following byte. A number of FLAC files found in the
+// wild have an ID3v2 tag prepended by rippers and converters, ahead of
+// the "fLaC" signature. If r doesn't begin with "ID3", it's left at its
+// original position.
+func skipID3v2Header(r io.ReadSeeker) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	head, err := readString(r, 3)
+	if err != nil {
+		return err
+	}
+	if head != "ID3" {
+		_, err = r.Seek(start, io.SeekStart)
+		return err
+	}
+
+	// version (2 bytes), then a 1-byte flags field
+	versionAndFlags, err := readBytes(r, 3)
+	if err != nil {
+		return err
+	}
+	flags := versionAndFlags[2]
+
+	sizeBytes, err := readBytes(r, 4)
+	if err != nil {
+		return err
+	}
+	size := int64(sizeBytes[0]&0x7f)<<21 | int64(sizeBytes[1]&0x7f)<<14 | int64(sizeBytes[2]&0x7f)<<7 | int64(sizeBytes[3]&0x7f)
+
+	end := start + 10 + size
+	if getBit(flags, 4) { // footer present (ID3v2.4 only)
+		end += 10
+	}
+
+	_, err = r.Seek(end, io.SeekStart)
+	return err
+}
+
 type metadataFLAC struct {
 	*metadataVorbis
 
 	flacmd5 []byte
+
+	// streamInfoRaw is the raw StreamInfo block payload, preserved verbatim
+	// so Write can reproduce it without re-deriving it from the parsed
+	// fields (it carries the min/max block and frame sizes, which aren't
+	// otherwise exposed).
+	streamInfoRaw []byte
+
+	// otherBlocks holds every metadata block read from the file that isn't
+	// the StreamInfo, VorbisComment, Picture or Padding block, preserved
+	// verbatim so Write can reproduce them.
+	otherBlocks []flacBlock
+
+	// metaRegionLen is the size, in bytes, of the metadata block region as
+	// read from the file (from just after the "fLaC" signature to the
+	// first audio frame), used by Write to size the trailing Padding block.
+	metaRegionLen int64
+
+	// blocksStart is the offset of the first metadata block, i.e. just
+	// after the "fLaC" signature. It's usually 4, but may be further into
+	// the file if a leading ID3v2 tag was skipped.
+	blocksStart int64
+}
+
+// flacBlock is a raw, unparsed FLAC metadata block, preserved for blocks
+// that Write doesn't need to (or can't) rebuild from parsed fields.
+type flacBlock struct {
+	typ  blockType
+	data []byte
 }
 
 func (m *metadataFLAC) readFLACMetadataBlock(r io.ReadSeeker) (last bool, err error) {
@@ -73,41 +166,61 @@ func (m *metadataFLAC) readFLACMetadataBlock(r io.ReadSeeker) (last bool, err er
 		return
 	}
 
-	switch blockType(blockHeader[0]) {
+	typ := blockType(blockHeader[0])
+
+	data, err := readBytes(r, blockLen)
+	if err != nil {
+		return
+	}
+
+	switch typ {
 	case streamInfoBlock:
-		err = m.readStreamInfoBlock(r)
+		m.streamInfoRaw = data
+		err = m.parseStreamInfoBlock(data)
 
 	case vorbisCommentBlock:
-		err = m.readVorbisComment(r)
+		err = m.readVorbisComment(bytes.NewReader(data))
 
 	case pictureBlock:
-		err = m.readPictureBlock(r)
+		err = m.readPictureBlock(bytes.NewReader(data))
+
+	case paddingBlock:
+		// dropped: Write always regenerates the trailing Padding block
+		// itself, sized to fill whatever slack remains, or freshPaddingLen
+		// when the metadata no longer fits the existing region.
 
 	default:
-		_, err = r.Seek(int64(blockLen), io.SeekCurrent)
+		m.otherBlocks = append(m.otherBlocks, flacBlock{typ, data})
 	}
 	return
 }
 
-func (m *metadataFLAC) readStreamInfoBlock(r io.ReadSeeker) error {
-	// skip 10 bytes
-	_, err := r.Seek(10, io.SeekCurrent);
-	if err != nil {
-		return err
+func (m *metadataFLAC) parseStreamInfoBlock(data []byte) error {
+	if len(data) < 34 {
+		return errors.New("tag: FLAC StreamInfo block too short")
 	}
 
+	// the first 10 bytes are minimum/maximum block and frame sizes, which
+	// aren't exposed by Metadata; they're kept verbatim in streamInfoRaw.
+	r := bytes.NewReader(data[10:])
+
 	// FLAC encodes non-Vorbis comments as Big Endian
 	streamInfo, err := readUint32BigEndian(r)
+	if err != nil {
+		return err
+	}
 	streamInfo2, err := readUint32BigEndian(r)
+	if err != nil {
+		return err
+	}
 
-	m.sampleRate		= uint(streamInfo >> 12)
-	m.channels		= uint((streamInfo >> 9) & 0x7) + 1
-	m.bitDepth		= uint((streamInfo >> 4) & 0x1f) + 1
-	m.samples		= uint64(streamInfo & 0xf) << 32 + uint64(streamInfo2) 
+	m.sampleRate = uint(streamInfo >> 12)
+	m.channels = uint((streamInfo>>9)&0x7) + 1
+	m.bitDepth = uint((streamInfo>>4)&0x1f) + 1
+	m.samples = uint64(streamInfo&0xf)<<32 + uint64(streamInfo2)
 
 	m.flacmd5, err = readBytes(r, 16)
-
-	return nil
+	return err
 }
 
 func (m *metadataFLAC) FileType() FileType {
@@ -136,3 +249,133 @@ func (m *metadataFLAC) Duration() uint {
 func FLACMD5Sum(m *metadataFLAC) []byte {
 	return m.flacmd5
 }
+
+// WriteFLACTags rewrites the VorbisComment and Picture metadata blocks in
+// rw with tags and pic, preserving the StreamInfo block and any other
+// existing metadata blocks verbatim, and leaving the audio frames
+// untouched wherever possible. If the resulting metadata region fits
+// within the space already occupied by the existing blocks (using any
+// trailing Padding block as slack), the blocks are rewritten in place;
+// otherwise the file is rewritten from the "fLaC" signature onwards with a
+// fresh freshPaddingLen Padding block, to allow future in-place edits.
+func WriteFLACTags(rw io.ReadWriteSeeker, tags map[string]string, pic *Picture) error {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	meta, err := ReadFLACTags(rw)
+	if err != nil {
+		return err
+	}
+	m := meta.(*metadataFLAC)
+
+	vendor := m.first("vendor")
+	m.c = make(map[string][]string, len(tags)+1)
+	m.c["vendor"] = []string{vendor}
+	for k, v := range tags {
+		m.c[strings.ToLower(k)] = []string{v}
+	}
+
+	m.pics = nil
+	if pic != nil {
+		m.pics = []*Picture{pic}
+	}
+
+	return m.Write(rw)
+}
+
+// Write rewrites the metadata block region of w (a FLAC stream previously
+// read with ReadFLACTags) using the StreamInfo and other blocks captured
+// at read time together with m's current VorbisComment fields and
+// Picture, the inverse of ReadFLACTags. If the new blocks don't fit
+// within the region ReadFLACTags originally measured (m.metaRegionLen),
+// it rewrites the audio frames that follow as well, using a fresh
+// freshPaddingLen Padding block.
+func (m *metadataFLAC) Write(w io.WriteSeeker) error {
+	blocks := make([]flacBlock, 0, len(m.otherBlocks)+3)
+	blocks = append(blocks, flacBlock{streamInfoBlock, m.streamInfoRaw})
+	blocks = append(blocks, m.otherBlocks...)
+
+	var vcBuf bytes.Buffer
+	if err := m.metadataVorbis.Write(&vcBuf); err != nil {
+		return err
+	}
+	blocks = append(blocks, flacBlock{vorbisCommentBlock, vcBuf.Bytes()})
+
+	for _, pic := range m.pics {
+		picData, err := encodePictureBlock(pic)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, flacBlock{pictureBlock, picData})
+	}
+
+	metaLen := int64(0)
+	for _, b := range blocks {
+		metaLen += 4 + int64(len(b.data))
+	}
+
+	// The Padding block appended below needs its own 4-byte header
+	// accounted for, or the in-place path writes metaRegionLen+4 bytes
+	// into a region that's only metaRegionLen bytes, clobbering the start
+	// of the audio frames that follow.
+	paddingLen := m.metaRegionLen - metaLen - 4
+	if paddingLen < 0 {
+		// Doesn't fit in the existing region: start over with fresh
+		// padding sized for future in-place edits, and relocate the audio
+		// frames that follow.
+		audio, err := readRestFromStart(w, m.blocksStart+m.metaRegionLen)
+		if err != nil {
+			return err
+		}
+		paddingLen = freshPaddingLen
+		blocks = append(blocks, flacBlock{paddingBlock, make([]byte, paddingLen)})
+		if _, err := w.Seek(m.blocksStart, io.SeekStart); err != nil {
+			return err
+		}
+		if err := writeFLACBlocks(w, blocks); err != nil {
+			return err
+		}
+		_, err = w.Write(audio)
+		return err
+	}
+
+	blocks = append(blocks, flacBlock{paddingBlock, make([]byte, paddingLen)})
+	if _, err := w.Seek(m.blocksStart, io.SeekStart); err != nil {
+		return err
+	}
+	return writeFLACBlocks(w, blocks)
+}
+
+// readRestFromStart reads everything from offset start to the end of w.
+func readRestFromStart(w io.WriteSeeker, start int64) ([]byte, error) {
+	r, ok := w.(io.ReadSeeker)
+	if !ok {
+		return nil, errors.New("tag: Write requires an io.ReadWriteSeeker to relocate audio frames when metadata grows; use WriteFLACTags")
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// writeFLACBlocks writes blocks in sequence, each with its own 1-byte
+// header (type, with the "last block" bit set on the final block) and
+// 3-byte big-endian length, the inverse of readFLACMetadataBlock.
+func writeFLACBlocks(w io.Writer, blocks []flacBlock) error {
+	for i, b := range blocks {
+		header := byte(b.typ)
+		if i == len(blocks)-1 {
+			header |= 1 << 7
+		}
+		if _, err := w.Write([]byte{header}); err != nil {
+			return err
+		}
+		if err := writeInt(w, 3, len(b.data)); err != nil {
+			return err
+		}
+		if _, err := w.Write(b.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
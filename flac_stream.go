@@ -0,0 +1,770 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FLACStream reads FLAC audio frames following the metadata blocks, for
+// callers that need more than tags: an exact sample count on files where
+// StreamInfo's samples field is zero (common for streaming encoders), or
+// decoded audio to verify against the StreamInfo MD5 (see FLACMD5Sum).
+// Unlike ReadFLACTags, it takes a plain io.Reader, since frame decoding
+// never needs to seek.
+type FLACStream struct {
+	br            *flacBitReader
+	sampleRate    uint32
+	bitsPerSample uint8
+}
+
+// ReadFLACStream reads past the FLAC metadata blocks in r, keeping only the
+// StreamInfo sample rate and bits per sample, and returns a FLACStream
+// ready to decode the audio frames that follow. Unlike ReadFLACTags, it
+// parses no tags or pictures, and r must begin with the "fLaC" signature:
+// a leading ID3v2 tag is not skipped.
+func ReadFLACStream(r io.Reader) (*FLACStream, error) {
+	flac, err := readString(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	if flac != "fLaC" {
+		return nil, errors.New("tag: expected 'fLaC'")
+	}
+
+	fs := &FLACStream{}
+	for {
+		header, err := readBytes(r, 1)
+		if err != nil {
+			return nil, err
+		}
+		last := getBit(header[0], 7)
+		typ := blockType(header[0] &^ (1 << 7))
+
+		blockLen, err := readInt(r, 3)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readBytes(r, blockLen)
+		if err != nil {
+			return nil, err
+		}
+
+		if typ == streamInfoBlock {
+			m := &metadataFLAC{metadataVorbis: newMetadataVorbis()}
+			if err := m.parseStreamInfoBlock(data); err != nil {
+				return nil, err
+			}
+			fs.sampleRate = uint32(m.sampleRate)
+			fs.bitsPerSample = uint8(m.bitDepth)
+		}
+
+		if last {
+			break
+		}
+	}
+
+	fs.br = &flacBitReader{src: &flacCRCReader{r: r}}
+	return fs, nil
+}
+
+// FLACFrameHeader is a decoded FLAC frame header.
+type FLACFrameHeader struct {
+	BlockSize  uint32
+	SampleRate uint32
+	Channels   uint8
+	// ChannelAssignment is the raw 4-bit channel assignment code: 0-7 are
+	// independent channels (Channels is n+1 of them); 8, 9 and 10 are
+	// left/side, right/side and mid/side stereo, already undone in
+	// FLACFrame.Samples.
+	ChannelAssignment byte
+	BitsPerSample     uint8
+	// Number is the frame number (fixed block size streams) or the first
+	// sample number in the frame (variable block size streams); see
+	// VariableBlockSize.
+	Number            uint64
+	VariableBlockSize bool
+}
+
+// FLACFrame is one decoded FLAC audio frame.
+type FLACFrame struct {
+	Header FLACFrameHeader
+	// Samples holds one slice per output channel (left-to-right), already
+	// restored from any mid/side or left/right-side decorrelation.
+	Samples [][]int32
+}
+
+// NextFrame decodes and returns the next audio frame, or io.EOF once the
+// stream is exhausted.
+func (fs *FLACStream) NextFrame() (*FLACFrame, error) {
+	hdr, bps, err := fs.readFrameHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	subBps := make([]uint8, hdr.Channels)
+	for i := range subBps {
+		subBps[i] = bps
+	}
+	switch hdr.ChannelAssignment {
+	case 8: // left/side: side channel (subframe 1) carries an extra bit
+		subBps[1]++
+	case 9: // right/side: side channel (subframe 0) carries an extra bit
+		subBps[0]++
+	case 10: // mid/side: side channel (subframe 1) carries an extra bit
+		subBps[1]++
+	}
+
+	subframes := make([][]int32, hdr.Channels)
+	for i := range subframes {
+		s, err := fs.br.readSubframe(int(hdr.BlockSize), subBps[i])
+		if err != nil {
+			return nil, err
+		}
+		subframes[i] = s
+	}
+
+	footerCRC := fs.br.src.crc16
+	fs.br.align()
+
+	crcBytes, err := readBytes(fs.br.src.r, 2)
+	if err != nil {
+		return nil, err
+	}
+	if got := uint16(crcBytes[0])<<8 | uint16(crcBytes[1]); got != footerCRC {
+		return nil, errors.New("tag: FLAC frame footer CRC-16 mismatch")
+	}
+
+	return &FLACFrame{
+		Header:  hdr,
+		Samples: decorrelateFLACChannels(hdr.ChannelAssignment, subframes),
+	}, nil
+}
+
+// readFrameHeader decodes a frame header, validating it against its
+// CRC-8, and returns it along with the frame's bits-per-sample (the same
+// value as FLACFrameHeader.BitsPerSample, returned separately purely for
+// subframe decoding's convenience).
+func (fs *FLACStream) readFrameHeader() (FLACFrameHeader, uint8, error) {
+	src := fs.br.src
+	src.crc8 = 0
+	src.crc16 = 0
+	src.trackCRC8 = true
+
+	b0, err := src.ReadByte()
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+	b1, err := src.ReadByte()
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+	if b0 != 0xFF || b1&0xFC != 0xF8 {
+		return FLACFrameHeader{}, 0, errors.New("tag: invalid FLAC frame sync code")
+	}
+	variableBlockSize := b1&0x01 != 0
+
+	b2, err := src.ReadByte()
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+	blockSizeCode := b2 >> 4
+	sampleRateCode := b2 & 0x0F
+
+	b3, err := src.ReadByte()
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+	channelCode := b3 >> 4
+	sampleSizeCode := (b3 >> 1) & 0x07
+
+	number, err := readFLACUTF8(src)
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+
+	var blockSize uint32
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		blockSize = uint32(v) + 1
+	case blockSizeCode == 7:
+		hi, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		lo, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		blockSize = (uint32(hi)<<8 | uint32(lo)) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return FLACFrameHeader{}, 0, errors.New("tag: reserved FLAC block size code")
+	}
+
+	var sampleRate uint32
+	switch sampleRateCode {
+	case 0:
+		sampleRate = fs.sampleRate
+	case 1:
+		sampleRate = 88200
+	case 2:
+		sampleRate = 176400
+	case 3:
+		sampleRate = 192000
+	case 4:
+		sampleRate = 8000
+	case 5:
+		sampleRate = 16000
+	case 6:
+		sampleRate = 22050
+	case 7:
+		sampleRate = 24000
+	case 8:
+		sampleRate = 32000
+	case 9:
+		sampleRate = 44100
+	case 10:
+		sampleRate = 48000
+	case 11:
+		sampleRate = 96000
+	case 12:
+		v, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		sampleRate = uint32(v) * 1000
+	case 13, 14:
+		hi, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		lo, err := src.ReadByte()
+		if err != nil {
+			return FLACFrameHeader{}, 0, err
+		}
+		sampleRate = uint32(hi)<<8 | uint32(lo)
+		if sampleRateCode == 14 {
+			sampleRate *= 10
+		}
+	default:
+		return FLACFrameHeader{}, 0, errors.New("tag: invalid FLAC sample rate code")
+	}
+
+	var channels uint8
+	switch {
+	case channelCode <= 7:
+		channels = channelCode + 1
+	case channelCode >= 8 && channelCode <= 10:
+		channels = 2
+	default:
+		return FLACFrameHeader{}, 0, errors.New("tag: reserved FLAC channel assignment code")
+	}
+
+	var bps uint8
+	switch sampleSizeCode {
+	case 0:
+		bps = fs.bitsPerSample
+	case 1:
+		bps = 8
+	case 2:
+		bps = 12
+	case 4:
+		bps = 16
+	case 5:
+		bps = 20
+	case 6:
+		bps = 24
+	default:
+		return FLACFrameHeader{}, 0, errors.New("tag: reserved FLAC sample size code")
+	}
+
+	expectedCRC8 := src.crc8
+	src.trackCRC8 = false
+	gotCRC8, err := src.ReadByte()
+	if err != nil {
+		return FLACFrameHeader{}, 0, err
+	}
+	if gotCRC8 != expectedCRC8 {
+		return FLACFrameHeader{}, 0, errors.New("tag: FLAC frame header CRC-8 mismatch")
+	}
+
+	return FLACFrameHeader{
+		BlockSize:         blockSize,
+		SampleRate:        sampleRate,
+		Channels:          channels,
+		ChannelAssignment: channelCode,
+		BitsPerSample:     bps,
+		Number:            number,
+		VariableBlockSize: variableBlockSize,
+	}, bps, nil
+}
+
+// decorrelateFLACChannels undoes the left/side, right/side or mid/side
+// stereo decorrelation, if assignment calls for it; for independent
+// channels (assignment 0-7) sub is already the final per-channel output.
+func decorrelateFLACChannels(assignment byte, sub [][]int32) [][]int32 {
+	switch assignment {
+	case 8: // left/side
+		n := len(sub[0])
+		right := make([]int32, n)
+		for i := 0; i < n; i++ {
+			right[i] = sub[0][i] - sub[1][i]
+		}
+		return [][]int32{sub[0], right}
+
+	case 9: // right/side
+		n := len(sub[1])
+		left := make([]int32, n)
+		for i := 0; i < n; i++ {
+			left[i] = sub[1][i] + sub[0][i]
+		}
+		return [][]int32{left, sub[1]}
+
+	case 10: // mid/side
+		n := len(sub[0])
+		left := make([]int32, n)
+		right := make([]int32, n)
+		for i := 0; i < n; i++ {
+			mid := sub[0][i]
+			side := sub[1][i]
+			mid2 := (mid << 1) | (side & 1)
+			left[i] = (mid2 + side) >> 1
+			right[i] = (mid2 - side) >> 1
+		}
+		return [][]int32{left, right}
+
+	default:
+		return sub
+	}
+}
+
+// flacCRCReader reads individual bytes from an underlying io.Reader,
+// maintaining a running frame header CRC-8 (while trackCRC8 is set) and
+// frame footer CRC-16, per the FLAC frame format.
+type flacCRCReader struct {
+	r         io.Reader
+	crc8      byte
+	crc16     uint16
+	trackCRC8 bool
+}
+
+func (c *flacCRCReader) ReadByte() (byte, error) {
+	b, err := readBytes(c.r, 1)
+	if err != nil {
+		return 0, err
+	}
+	if c.trackCRC8 {
+		c.crc8 = flacCRC8Table[c.crc8^b[0]]
+	}
+	c.crc16 = (c.crc16 << 8) ^ flacCRC16Table[byte(c.crc16>>8)^b[0]]
+	return b[0], nil
+}
+
+// flacCRC8Table is the CRC-8 table (polynomial 0x07, initial value 0)
+// FLAC uses to check frame headers.
+var flacCRC8Table = func() [256]byte {
+	var t [256]byte
+	for i := range t {
+		crc := byte(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = (crc << 1) ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// flacCRC16Table is the CRC-16 table (polynomial 0x8005, initial value 0)
+// FLAC uses to check frame footers.
+var flacCRC16Table = func() [256]uint16 {
+	var t [256]uint16
+	for i := range t {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// readFLACUTF8 reads a FLAC/UTF-8 coded number: a variable-length
+// encoding of the same shape as UTF-8, extended to carry up to 36 bits
+// (used for sample numbers).
+func readFLACUTF8(src *flacCRCReader) (uint64, error) {
+	b0, err := src.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b0&0x80 == 0 {
+		return uint64(b0), nil
+	}
+
+	var n int
+	var val uint64
+	switch {
+	case b0&0xE0 == 0xC0:
+		n, val = 1, uint64(b0&0x1F)
+	case b0&0xF0 == 0xE0:
+		n, val = 2, uint64(b0&0x0F)
+	case b0&0xF8 == 0xF0:
+		n, val = 3, uint64(b0&0x07)
+	case b0&0xFC == 0xF8:
+		n, val = 4, uint64(b0&0x03)
+	case b0&0xFE == 0xFC:
+		n, val = 5, uint64(b0&0x01)
+	case b0 == 0xFE:
+		n, val = 6, 0
+	default:
+		return 0, errors.New("tag: invalid FLAC UTF-8 coded number")
+	}
+
+	for i := 0; i < n; i++ {
+		b, err := src.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b&0xC0 != 0x80 {
+			return 0, errors.New("tag: invalid FLAC UTF-8 continuation byte")
+		}
+		val = (val << 6) | uint64(b&0x3F)
+	}
+	return val, nil
+}
+
+// flacBitReader reads individual bits, MSB first, from a flacCRCReader.
+type flacBitReader struct {
+	src   *flacCRCReader
+	buf   uint64
+	nbits uint
+}
+
+func (br *flacBitReader) fill() error {
+	b, err := br.src.ReadByte()
+	if err != nil {
+		return err
+	}
+	br.buf = (br.buf << 8) | uint64(b)
+	br.nbits += 8
+	return nil
+}
+
+// ReadBits reads the next n bits (n <= 32) as an unsigned value.
+func (br *flacBitReader) ReadBits(n uint) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	for br.nbits < n {
+		if err := br.fill(); err != nil {
+			return 0, err
+		}
+	}
+	br.nbits -= n
+	return (br.buf >> br.nbits) & ((1 << n) - 1), nil
+}
+
+// ReadSigned reads the next n bits as a two's complement signed value.
+func (br *flacBitReader) ReadSigned(n uint) (int32, error) {
+	v, err := br.ReadBits(n)
+	if err != nil {
+		return 0, err
+	}
+	if v&(1<<(n-1)) != 0 {
+		return int32(v) - int32(1<<n), nil
+	}
+	return int32(v), nil
+}
+
+// ReadUnary reads a unary-coded value: the count of 0 bits before the
+// next 1 bit.
+func (br *flacBitReader) ReadUnary() (uint32, error) {
+	var n uint32
+	for {
+		b, err := br.ReadBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+// align discards any bits buffered beyond the current byte, so the next
+// byte fill() or a raw read from the underlying reader picks up frame
+// footer bytes rather than padding already accounted for here.
+func (br *flacBitReader) align() {
+	br.buf = 0
+	br.nbits = 0
+}
+
+// flacFixedCoeffs holds the fixed predictor coefficients for orders 0-4,
+// per the FLAC format spec.
+var flacFixedCoeffs = [][]int32{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func (br *flacBitReader) readSubframe(blockSize int, bps uint8) ([]int32, error) {
+	padBit, err := br.ReadBits(1)
+	if err != nil {
+		return nil, err
+	}
+	if padBit != 0 {
+		return nil, errors.New("tag: invalid FLAC subframe header padding bit")
+	}
+
+	typeCode, err := br.ReadBits(6)
+	if err != nil {
+		return nil, err
+	}
+
+	wastedFlag, err := br.ReadBits(1)
+	if err != nil {
+		return nil, err
+	}
+	var wasted uint
+	if wastedFlag == 1 {
+		u, err := br.ReadUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(u) + 1
+		bps -= uint8(wasted)
+	}
+
+	var out []int32
+	switch {
+	case typeCode == 0:
+		out, err = br.readConstantSubframe(blockSize, bps)
+	case typeCode == 1:
+		out, err = br.readVerbatimSubframe(blockSize, bps)
+	case typeCode >= 8 && typeCode <= 12:
+		out, err = br.readFixedSubframe(blockSize, bps, int(typeCode-8))
+	case typeCode >= 32:
+		out, err = br.readLPCSubframe(blockSize, bps, int(typeCode-32)+1)
+	default:
+		return nil, fmt.Errorf("tag: reserved FLAC subframe type %d", typeCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return out, nil
+}
+
+func (br *flacBitReader) readConstantSubframe(blockSize int, bps uint8) ([]int32, error) {
+	v, err := br.ReadSigned(uint(bps))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int32, blockSize)
+	for i := range out {
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (br *flacBitReader) readVerbatimSubframe(blockSize int, bps uint8) ([]int32, error) {
+	out := make([]int32, blockSize)
+	for i := range out {
+		v, err := br.ReadSigned(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (br *flacBitReader) readFixedSubframe(blockSize int, bps uint8, order int) ([]int32, error) {
+	out := make([]int32, blockSize)
+	for i := 0; i < order; i++ {
+		v, err := br.ReadSigned(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+
+	residual, err := br.readResidual(blockSize, order)
+	if err != nil {
+		return nil, err
+	}
+
+	coeffs := flacFixedCoeffs[order]
+	for i := order; i < blockSize; i++ {
+		var pred int64
+		for j, c := range coeffs {
+			pred += int64(c) * int64(out[i-1-j])
+		}
+		out[i] = int32(pred) + residual[i-order]
+	}
+	return out, nil
+}
+
+func (br *flacBitReader) readLPCSubframe(blockSize int, bps uint8, order int) ([]int32, error) {
+	out := make([]int32, blockSize)
+	for i := 0; i < order; i++ {
+		v, err := br.ReadSigned(uint(bps))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+
+	precision, err := br.ReadBits(4)
+	if err != nil {
+		return nil, err
+	}
+	precision++ // stored as precision-1
+
+	shiftRaw, err := br.ReadBits(5)
+	if err != nil {
+		return nil, err
+	}
+	shift := int32(shiftRaw)
+	if shift&0x10 != 0 { // sign bit of the 5-bit field
+		shift -= 32
+	}
+
+	coeffs := make([]int32, order)
+	for i := range coeffs {
+		v, err := br.ReadSigned(uint(precision))
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = v
+	}
+
+	residual, err := br.readResidual(blockSize, order)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := order; i < blockSize; i++ {
+		var pred int64
+		for j, c := range coeffs {
+			pred += int64(c) * int64(out[i-1-j])
+		}
+		if shift >= 0 {
+			pred >>= uint(shift)
+		} else {
+			pred <<= uint(-shift)
+		}
+		out[i] = int32(pred) + residual[i-order]
+	}
+	return out, nil
+}
+
+// readResidual reads the Rice-coded (or raw, escaped) residual following
+// a FIXED or LPC subframe's warmup samples, partitioned per the FLAC
+// format spec.
+func (br *flacBitReader) readResidual(blockSize, predictorOrder int) ([]int32, error) {
+	method, err := br.ReadBits(2)
+	if err != nil {
+		return nil, err
+	}
+
+	var paramBits uint
+	var escape uint64
+	switch method {
+	case 0:
+		paramBits, escape = 4, 0xF
+	case 1:
+		paramBits, escape = 5, 0x1F
+	default:
+		return nil, fmt.Errorf("tag: reserved FLAC residual coding method %d", method)
+	}
+
+	partOrder, err := br.ReadBits(4)
+	if err != nil {
+		return nil, err
+	}
+	partitions := 1 << partOrder
+
+	residual := make([]int32, blockSize-predictorOrder)
+	pos := 0
+	for p := 0; p < partitions; p++ {
+		n := blockSize >> partOrder
+		if p == 0 {
+			n -= predictorOrder
+		}
+
+		param, err := br.ReadBits(paramBits)
+		if err != nil {
+			return nil, err
+		}
+
+		if param == escape {
+			rawBits, err := br.ReadBits(5)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < n; i++ {
+				v, err := br.ReadSigned(uint(rawBits))
+				if err != nil {
+					return nil, err
+				}
+				residual[pos] = v
+				pos++
+			}
+			continue
+		}
+
+		for i := 0; i < n; i++ {
+			q, err := br.ReadUnary()
+			if err != nil {
+				return nil, err
+			}
+			r, err := br.ReadBits(uint(param))
+			if err != nil {
+				return nil, err
+			}
+			residual[pos] = zigzagDecode((uint64(q) << param) | r)
+			pos++
+		}
+	}
+	return residual, nil
+}
+
+// zigzagDecode maps a Rice-coded unsigned value back to its signed
+// residual, the inverse of the zigzag folding FLAC encoders apply before
+// Rice coding.
+func zigzagDecode(u uint64) int32 {
+	if u&1 != 0 {
+		return int32(-((u + 1) >> 1))
+	}
+	return int32(u >> 1)
+}
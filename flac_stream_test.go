@@ -0,0 +1,273 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"testing"
+)
+
+// buildTestFLACStreamInfo builds a minimal 34-byte StreamInfo block payload
+// for sampleRate/channels/bps, with a zero sample count and zeroed
+// min/max block and frame sizes and MD5.
+func buildTestFLACStreamInfo(sampleRate uint32, channels, bps uint8) []byte {
+	data := make([]byte, 34)
+	streamInfo := sampleRate<<12 | uint32(channels-1)<<9 | uint32(bps-1)<<4
+	data[10] = byte(streamInfo >> 24)
+	data[11] = byte(streamInfo >> 16)
+	data[12] = byte(streamInfo >> 8)
+	data[13] = byte(streamInfo)
+	return data
+}
+
+// buildTestFLACFrame returns a single-channel, 8-bit, fixed-block-size
+// (192 sample) FLAC frame carrying one CONSTANT subframe of sample, with a
+// correctly computed header CRC-8 and footer CRC-16.
+func buildTestFLACFrame(sample int8) []byte {
+	header := []byte{0xFF, 0xF8, 0x10, 0x02, 0x00} // 192 samples, sample rate from StreamInfo, mono, 8 bps, frame #0
+	var crc8 byte
+	for _, b := range header {
+		crc8 = flacCRC8Table[crc8^b]
+	}
+
+	frame := append(append([]byte{}, header...), crc8)
+	frame = append(frame, 0x00, byte(sample)) // CONSTANT subframe, no wasted bits, sample
+
+	var crc16 uint16
+	for _, b := range frame {
+		crc16 = (crc16 << 8) ^ flacCRC16Table[byte(crc16>>8)^b]
+	}
+	return append(frame, byte(crc16>>8), byte(crc16))
+}
+
+// testBitWriter packs bits MSB-first into bytes, the write-side mirror of
+// flacBitReader, for assembling subframes whose fields don't land on byte
+// boundaries (Rice parameters, unary-coded quotients, LPC coefficients).
+type testBitWriter struct {
+	buf  []byte
+	acc  uint64
+	nbit uint
+}
+
+func (w *testBitWriter) writeBits(v uint64, n uint) {
+	w.acc = (w.acc << n) | (v & ((1 << n) - 1))
+	w.nbit += n
+	for w.nbit >= 8 {
+		w.nbit -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbit))
+	}
+}
+
+// writeUnary writes q zero bits followed by a terminating 1 bit, the
+// encoding ReadUnary decodes.
+func (w *testBitWriter) writeUnary(q uint64) {
+	for ; q > 0; q-- {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(1, 1)
+}
+
+// bytes flushes any partial trailing byte, zero-padded, matching the
+// padding a real FLAC encoder emits before the byte-aligned frame footer.
+func (w *testBitWriter) bytes() []byte {
+	if w.nbit > 0 {
+		w.buf = append(w.buf, byte(w.acc<<(8-w.nbit)))
+		w.nbit = 0
+	}
+	return w.buf
+}
+
+// buildTestFLACFrameCustomBlockSize returns a single-channel, 8-bit FLAC
+// frame of blockSize samples (encoded via the 8-bit custom block size
+// field) carrying subframeBits as its one subframe, with correctly
+// computed header CRC-8 and footer CRC-16.
+func buildTestFLACFrameCustomBlockSize(blockSize int, subframeBits []byte) []byte {
+	header := []byte{0xFF, 0xF8, 0x60, 0x02, 0x00, byte(blockSize - 1)} // custom 8-bit block size, sample rate from StreamInfo, mono, 8 bps, frame #0
+	var crc8 byte
+	for _, b := range header {
+		crc8 = flacCRC8Table[crc8^b]
+	}
+
+	frame := append(append([]byte{}, header...), crc8)
+	frame = append(frame, subframeBits...)
+
+	var crc16 uint16
+	for _, b := range frame {
+		crc16 = (crc16 << 8) ^ flacCRC16Table[byte(crc16>>8)^b]
+	}
+	return append(frame, byte(crc16>>8), byte(crc16))
+}
+
+// TestReadFLACStreamDecodesFixedSubframe covers readFixedSubframe and the
+// partitioned Rice residual decoder (readResidual/zigzagDecode), with two
+// partitions so both a plain Rice-coded partition and an escaped
+// (raw-bits) partition are exercised in the same frame.
+func TestReadFLACStreamDecodesFixedSubframe(t *testing.T) {
+	var w testBitWriter
+	w.writeBits(0, 1)  // subframe header padding bit
+	w.writeBits(9, 6)  // FIXED order 1 (8 + order)
+	w.writeBits(0, 1)  // no wasted bits
+	w.writeBits(10, 8) // warmup sample
+
+	w.writeBits(0, 2) // residual coding method 0 (4-bit Rice parameters)
+	w.writeBits(1, 4) // partition order 1 -> 2 partitions
+
+	// Partition 0 (3 residuals, blockSize/2 - order): Rice param 2, each
+	// coding zigzag(2) = 4 as unary q=1, r=0.
+	w.writeBits(2, 4)
+	for i := 0; i < 3; i++ {
+		w.writeUnary(1)
+		w.writeBits(0, 2)
+	}
+
+	// Partition 1 (4 residuals): escaped raw 5-bit signed values, not
+	// Rice/zigzag coded.
+	w.writeBits(0xF, 4) // escape code
+	w.writeBits(5, 5)   // raw value width
+	for _, v := range []uint64{0b11110, 0b00011, 0b11111, 0b00000} {
+		w.writeBits(v, 5)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	si := buildTestFLACStreamInfo(44100, 1, 8)
+	buf.WriteByte(0x80)
+	buf.Write([]byte{0, 0, byte(len(si))})
+	buf.Write(si)
+	buf.Write(buildTestFLACFrameCustomBlockSize(8, w.bytes()))
+
+	fs, err := ReadFLACStream(&buf)
+	if err != nil {
+		t.Fatalf("ReadFLACStream: %v", err)
+	}
+	frame, err := fs.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+
+	want := []int32{10, 12, 14, 16, 14, 17, 16, 16}
+	if len(frame.Samples) != 1 || len(frame.Samples[0]) != len(want) {
+		t.Fatalf("unexpected sample layout: channels=%d samples=%d", len(frame.Samples), len(frame.Samples[0]))
+	}
+	for i, s := range frame.Samples[0] {
+		if s != want[i] {
+			t.Fatalf("sample[%d] = %d, want %d (samples=%v)", i, s, want[i], frame.Samples[0])
+		}
+	}
+}
+
+// TestReadFLACStreamDecodesLPCSubframe covers readLPCSubframe, including a
+// non-trivial (positive, shift-right) predictor shift and a coefficient
+// that doesn't fit the 4-bit fixed-predictor coefficients readFixedSubframe
+// uses.
+func TestReadFLACStreamDecodesLPCSubframe(t *testing.T) {
+	var w testBitWriter
+	w.writeBits(0, 1)   // subframe header padding bit
+	w.writeBits(32, 6)  // LPC order 1 (32 + order - 1)
+	w.writeBits(0, 1)   // no wasted bits
+	w.writeBits(100, 8) // warmup sample
+
+	w.writeBits(5, 4)  // precision - 1 (precision = 6 bits)
+	w.writeBits(4, 5)  // shift = 4
+	w.writeBits(16, 6) // coefficient 16: pred = (16*prev)>>4 == prev
+
+	w.writeBits(0, 2) // residual coding method 0
+	w.writeBits(0, 4) // partition order 0 -> a single partition
+	w.writeBits(3, 4) // Rice param 3
+
+	// 5 residuals, each zigzag(3) = 6 as unary q=0, r=6.
+	for i := 0; i < 5; i++ {
+		w.writeUnary(0)
+		w.writeBits(6, 3)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	si := buildTestFLACStreamInfo(44100, 1, 8)
+	buf.WriteByte(0x80)
+	buf.Write([]byte{0, 0, byte(len(si))})
+	buf.Write(si)
+	buf.Write(buildTestFLACFrameCustomBlockSize(6, w.bytes()))
+
+	fs, err := ReadFLACStream(&buf)
+	if err != nil {
+		t.Fatalf("ReadFLACStream: %v", err)
+	}
+	frame, err := fs.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+
+	want := []int32{100, 103, 106, 109, 112, 115}
+	if len(frame.Samples) != 1 || len(frame.Samples[0]) != len(want) {
+		t.Fatalf("unexpected sample layout: channels=%d samples=%d", len(frame.Samples), len(frame.Samples[0]))
+	}
+	for i, s := range frame.Samples[0] {
+		if s != want[i] {
+			t.Fatalf("sample[%d] = %d, want %d (samples=%v)", i, s, want[i], frame.Samples[0])
+		}
+	}
+}
+
+func TestReadFLACStreamDecodesConstantSubframe(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+
+	si := buildTestFLACStreamInfo(44100, 1, 8)
+	buf.WriteByte(0x80) // last block, StreamInfo
+	buf.Write([]byte{0, 0, byte(len(si))})
+	buf.Write(si)
+	buf.Write(buildTestFLACFrame(42))
+
+	fs, err := ReadFLACStream(&buf)
+	if err != nil {
+		t.Fatalf("ReadFLACStream: %v", err)
+	}
+
+	frame, err := fs.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if frame.Header.BlockSize != 192 {
+		t.Errorf("BlockSize = %d, want 192", frame.Header.BlockSize)
+	}
+	if frame.Header.SampleRate != 44100 {
+		t.Errorf("SampleRate = %d, want 44100", frame.Header.SampleRate)
+	}
+	if len(frame.Samples) != 1 || len(frame.Samples[0]) != 192 {
+		t.Fatalf("unexpected sample layout: channels=%d samples=%d", len(frame.Samples), len(frame.Samples[0]))
+	}
+	for i, s := range frame.Samples[0] {
+		if s != 42 {
+			t.Fatalf("sample[%d] = %d, want 42", i, s)
+		}
+	}
+
+	if _, err := fs.NextFrame(); err == nil {
+		t.Fatal("expected an error reading past the last frame, got nil")
+	}
+}
+
+func TestReadFLACStreamRejectsBadFooterCRC(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+
+	si := buildTestFLACStreamInfo(44100, 1, 8)
+	buf.WriteByte(0x80)
+	buf.Write([]byte{0, 0, byte(len(si))})
+	buf.Write(si)
+
+	frame := buildTestFLACFrame(42)
+	frame[len(frame)-1] ^= 0xFF // corrupt the footer CRC-16
+	buf.Write(frame)
+
+	fs, err := ReadFLACStream(&buf)
+	if err != nil {
+		t.Fatalf("ReadFLACStream: %v", err)
+	}
+	if _, err := fs.NextFrame(); err == nil {
+		t.Fatal("expected a footer CRC-16 mismatch error, got nil")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// seekBuffer is an io.ReadWriteSeeker (with Truncate) backed by a
+// growable byte slice, for exercising WriteFLACTags/WriteOggVorbisTags
+// against something other than an os.File.
+type seekBuffer struct {
+	buf []byte
+	pos int64
+}
+
+func (b *seekBuffer) Read(p []byte) (int, error) {
+	if b.pos >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[b.pos:])
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	end := b.pos + int64(len(p))
+	if end > int64(len(b.buf)) {
+		grown := make([]byte, end)
+		copy(grown, b.buf)
+		b.buf = grown
+	}
+	n := copy(b.buf[b.pos:end], p)
+	b.pos = end
+	return n, nil
+}
+
+func (b *seekBuffer) Truncate(size int64) error {
+	if size > int64(len(b.buf)) {
+		grown := make([]byte, size)
+		copy(grown, b.buf)
+		b.buf = grown
+		return nil
+	}
+	b.buf = b.buf[:size]
+	return nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = b.pos + offset
+	case io.SeekEnd:
+		pos = int64(len(b.buf)) + offset
+	default:
+		return 0, errors.New("seekBuffer: invalid whence")
+	}
+	if pos < 0 {
+		return 0, errors.New("seekBuffer: negative position")
+	}
+	b.pos = pos
+	return pos, nil
+}
+
+// buildTestFLACFile builds a minimal FLAC stream: a StreamInfo block, a
+// VorbisComment block for tags, and freshPaddingLen of Padding, so it has
+// slack for WriteFLACTags to rewrite tags in place.
+func buildTestFLACFile(tags map[string]string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+
+	si := buildTestFLACStreamInfo(44100, 2, 16)
+
+	vc := newMetadataVorbis()
+	vc.c["vendor"] = []string{"test"}
+	for k, v := range tags {
+		vc.c[k] = []string{v}
+	}
+	var vcBuf bytes.Buffer
+	vc.Write(&vcBuf)
+
+	blocks := []flacBlock{
+		{streamInfoBlock, si},
+		{vorbisCommentBlock, vcBuf.Bytes()},
+		{paddingBlock, make([]byte, freshPaddingLen)},
+	}
+	writeFLACBlocks(&buf, blocks)
+
+	buf.Write(bytes.Repeat([]byte{0xAB}, 64)) // stand-in audio frames
+	return buf.Bytes()
+}
+
+func TestWriteFLACTagsRoundTrip(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestFLACFile(map[string]string{"title": "old title"})}
+
+	if err := WriteFLACTags(rw, map[string]string{"title": "new title", "artist": "someone"}, nil); err != nil {
+		t.Fatalf("WriteFLACTags: %v", err)
+	}
+
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	m, err := ReadFLACTags(rw)
+	if err != nil {
+		t.Fatalf("ReadFLACTags: %v", err)
+	}
+	if got := m.Title(); got != "new title" {
+		t.Errorf("Title() = %q, want %q", got, "new title")
+	}
+	if got := m.Artist(); got != "someone" {
+		t.Errorf("Artist() = %q, want %q", got, "someone")
+	}
+
+	audio := rw.buf[len(rw.buf)-64:]
+	for _, b := range audio {
+		if b != 0xAB {
+			t.Fatalf("audio frames were touched by an in-place tag rewrite: %v", audio)
+		}
+	}
+}
+
+// TestWriteFLACTagsWithPictureRoundTrip covers WriteFLACTags writing a
+// Picture, since pictureBlock is its own FLAC metadata block rather than
+// an inlined Vorbis comment field (the encoding Ogg uses).
+func TestWriteFLACTagsWithPictureRoundTrip(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestFLACFile(map[string]string{"title": "old title"})}
+
+	pic := &Picture{
+		MIMEType:    "image/jpeg",
+		Description: "cover",
+		Data:        bytes.Repeat([]byte{0xEE}, 4096),
+	}
+	if err := WriteFLACTags(rw, map[string]string{"title": "new title"}, pic); err != nil {
+		t.Fatalf("WriteFLACTags: %v", err)
+	}
+
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	m, err := ReadFLACTags(rw)
+	if err != nil {
+		t.Fatalf("ReadFLACTags: %v", err)
+	}
+	got := m.Picture()
+	if got == nil {
+		t.Fatal("Picture() = nil, want the written picture")
+	}
+	if got.MIMEType != pic.MIMEType || got.Description != pic.Description || !bytes.Equal(got.Data, pic.Data) {
+		t.Errorf("Picture() = %+v, want %+v", got, pic)
+	}
+}
+
+// TestWriteFLACTagsInPlaceSameSize rewrites a single existing field to a
+// same-length value, without adding any new field, so the new metadata
+// fits in the existing region and takes the in-place path rather than the
+// relocate-audio path TestWriteFLACTagsGrowsBeyondPadding exercises.
+func TestWriteFLACTagsInPlaceSameSize(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestFLACFile(map[string]string{"title": "old title"})}
+
+	if err := WriteFLACTags(rw, map[string]string{"title": "new title"}, nil); err != nil {
+		t.Fatalf("WriteFLACTags: %v", err)
+	}
+
+	audio := rw.buf[len(rw.buf)-64:]
+	for _, b := range audio {
+		if b != 0xAB {
+			t.Fatalf("audio frames were corrupted by an in-place tag rewrite: %v", audio)
+		}
+	}
+
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	m, err := ReadFLACTags(rw)
+	if err != nil {
+		t.Fatalf("ReadFLACTags: %v", err)
+	}
+	if got := m.Title(); got != "new title" {
+		t.Errorf("Title() = %q, want %q", got, "new title")
+	}
+}
+
+func TestWriteFLACTagsGrowsBeyondPadding(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestFLACFile(map[string]string{"title": "x"})}
+
+	big := make(map[string]string, 200)
+	for i := 0; i < 200; i++ {
+		big[string(rune('a'+i%26))+string(rune('0'+i/26))] = "a value long enough to blow past the padding slack many times over"
+	}
+	if err := WriteFLACTags(rw, big, nil); err != nil {
+		t.Fatalf("WriteFLACTags: %v", err)
+	}
+
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	m, err := ReadFLACTags(rw)
+	if err != nil {
+		t.Fatalf("ReadFLACTags: %v", err)
+	}
+	vg, ok := m.(interface{ Values(string) []string })
+	if !ok {
+		t.Fatalf("%T does not implement Values", m)
+	}
+	for k, v := range big {
+		if got := vg.Values(k); len(got) != 1 || got[0] != v {
+			t.Fatalf("Values(%q) = %v, want [%q]", k, got, v)
+		}
+	}
+}
+
+func TestParseStreamInfoBlockTooShort(t *testing.T) {
+	m := &metadataFLAC{metadataVorbis: newMetadataVorbis()}
+	if err := m.parseStreamInfoBlock(make([]byte, 4)); err == nil {
+		t.Fatal("expected an error for a truncated StreamInfo block, got nil")
+	}
+}
+
+func TestReadFLACTagsSkipsLeadingID3v2Header(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("ID3")
+	buf.Write([]byte{4, 0, 0})     // version 2.4.0, flags 0
+	buf.Write([]byte{0, 0, 0, 10}) // synchsafe size 10
+	buf.Write(make([]byte, 10))    // id3 tag body
+	buf.Write(buildTestFLACFile(map[string]string{"title": "id3-prefixed"}))
+
+	m, err := ReadFLACTags(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadFLACTags: %v", err)
+	}
+	if got := m.Title(); got != "id3-prefixed" {
+		t.Errorf("Title() = %q, want %q", got, "id3-prefixed")
+	}
+}
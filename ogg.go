@@ -0,0 +1,449 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// oggPageHeaderLen is the size of a fixed Ogg page header, not including
+// the segment table: capture_pattern(4) + version(1) + header_type(1) +
+// granule_position(8) + serial_number(4) + sequence_number(4) + crc(4) +
+// page_segments(1).
+const oggPageHeaderLen = 27
+
+const (
+	oggContinuedPacket = 1 << 0
+	oggFirstPage       = 1 << 1
+	oggLastPage        = 1 << 2
+)
+
+// oggPage is a single decoded Ogg page: its header fields and its raw,
+// unsegmented payload.
+type oggPage struct {
+	headerType byte
+	granulePos uint64
+	serial     uint32
+	sequence   uint32
+	segTable   []byte
+	payload    []byte
+}
+
+// readOggPage reads and decodes one Ogg page from r.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	sig, err := readString(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	if sig != "OggS" {
+		return nil, errors.New("tag: expected 'OggS'")
+	}
+	if _, err := readBytes(r, 1); err != nil { // version
+		return nil, err
+	}
+	headerType, err := readBytes(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	granuleBytes, err := readBytes(r, 8)
+	if err != nil {
+		return nil, err
+	}
+	serialBytes, err := readBytes(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	seqBytes, err := readBytes(r, 4)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readBytes(r, 4); err != nil { // crc, recomputed on write
+		return nil, err
+	}
+	nSeg, err := readBytes(r, 1)
+	if err != nil {
+		return nil, err
+	}
+	segTable, err := readBytes(r, int(nSeg[0]))
+	if err != nil {
+		return nil, err
+	}
+	payloadLen := 0
+	for _, s := range segTable {
+		payloadLen += int(s)
+	}
+	payload, err := readBytes(r, payloadLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oggPage{
+		headerType: headerType[0],
+		granulePos: uint64(getUintLittleEndian(granuleBytes)),
+		serial:     uint32(getUintLittleEndian(serialBytes)),
+		sequence:   uint32(getUintLittleEndian(seqBytes)),
+		segTable:   segTable,
+		payload:    payload,
+	}, nil
+}
+
+// packets splits p's payload into packets using its segment table,
+// reporting whether the final packet continues onto the next page (its
+// last lacing value is 255).
+func (p *oggPage) packets() (pkts [][]byte, continued bool) {
+	start := 0
+	off := 0
+	for i, seg := range p.segTable {
+		off += int(seg)
+		if seg < 255 {
+			pkts = append(pkts, p.payload[start:off])
+			start = off
+		} else if i == len(p.segTable)-1 {
+			continued = true
+		}
+	}
+	if continued {
+		pkts = append(pkts, p.payload[start:off])
+	}
+	return
+}
+
+// lacePacket returns the segment-table lacing values for a single packet
+// of length n that terminates within its page.
+func lacePacket(n int) []byte {
+	segs := make([]byte, 0, n/255+1)
+	for n >= 255 {
+		segs = append(segs, 255)
+		n -= 255
+	}
+	return append(segs, byte(n))
+}
+
+// writeOggPage writes a single Ogg page with the given fields; the CRC is
+// computed over the whole page with the CRC field itself zeroed, per the
+// Ogg framing spec.
+func writeOggPage(w io.Writer, headerType byte, granulePos uint64, serial, sequence uint32, segTable, payload []byte) error {
+	if len(segTable) > oggMaxSegments {
+		return errors.New("tag: Ogg segment table exceeds 255 entries")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("OggS")
+	buf.WriteByte(0) // version
+	buf.WriteByte(headerType)
+	if err := writeUint64LittleEndian(&buf, granulePos); err != nil {
+		return err
+	}
+	if err := writeUint32LittleEndian2(&buf, serial); err != nil {
+		return err
+	}
+	if err := writeUint32LittleEndian2(&buf, sequence); err != nil {
+		return err
+	}
+	buf.Write([]byte{0, 0, 0, 0}) // crc placeholder
+	buf.WriteByte(byte(len(segTable)))
+	buf.Write(segTable)
+	buf.Write(payload)
+
+	page := buf.Bytes()
+	crc := oggCRC32(page)
+	page[22] = byte(crc)
+	page[23] = byte(crc >> 8)
+	page[24] = byte(crc >> 16)
+	page[25] = byte(crc >> 24)
+
+	_, err := w.Write(page)
+	return err
+}
+
+// writeUint64LittleEndian and writeUint32LittleEndian2 write Ogg's
+// little-endian header fields; named distinctly from the package's
+// existing big/little-endian uint32 helpers, which Ogg's CRC field layout
+// (computed over the little-endian header) doesn't otherwise need.
+func writeUint64LittleEndian(w io.Writer, v uint64) error {
+	b := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		b[i] = byte(v >> (8 * uint(i)))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func writeUint32LittleEndian2(w io.Writer, v uint32) error {
+	b := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		b[i] = byte(v >> (8 * uint(i)))
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// oggCRCTable is the CRC-32 table used by Ogg page checksums: a
+// non-reflected CRC-32 with polynomial 0x04c11db7, as specified by RFC
+// 3533 and implemented by libogg's ogg_page_checksum_set.
+var oggCRCTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+func oggCRC32(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// isVorbisHeaderPacket reports whether packet is a Vorbis header packet of
+// the given type (1 = identification, 3 = comment, 5 = setup), per the
+// Vorbis I spec's common header decode.
+func isVorbisHeaderPacket(packet []byte, typ byte) bool {
+	return len(packet) > 7 && packet[0] == typ && string(packet[1:7]) == "vorbis"
+}
+
+// WriteOggVorbisTags rewrites the Vorbis comment header packet carried in
+// the Ogg container rw with tags and pic, preserving the identification
+// and setup header packets and the payload of all following audio pages;
+// if the number of header pages changes, the audio pages' sequence
+// numbers are shifted to match and their CRCs recomputed. This
+// supports the common layout produced by libvorbis, where the
+// identification header is alone on the first page and the comment and
+// setup headers end together on a page boundary, with no audio data
+// packed alongside them.
+func WriteOggVorbisTags(rw io.ReadWriteSeeker, tags map[string]string, pic *Picture) error {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	idPage, err := readOggPage(rw)
+	if err != nil {
+		return err
+	}
+	idPackets, _ := idPage.packets()
+	if len(idPackets) != 1 || !isVorbisHeaderPacket(idPackets[0], 1) {
+		return errors.New("tag: expected a single Vorbis identification header packet on the first Ogg page")
+	}
+
+	var headerPages []*oggPage
+	var packets [][]byte
+	var partial []byte
+	for len(packets) < 2 {
+		p, err := readOggPage(rw)
+		if err != nil {
+			return err
+		}
+		headerPages = append(headerPages, p)
+
+		pkts, continued := p.packets()
+		for i, pkt := range pkts {
+			if i == 0 && len(partial) > 0 {
+				partial = append(partial, pkt...)
+				pkt = partial
+				partial = nil
+			}
+			if continued && i == len(pkts)-1 {
+				partial = pkt
+				continue
+			}
+			packets = append(packets, pkt)
+		}
+	}
+	if len(packets) != 2 || !isVorbisHeaderPacket(packets[0], 3) || !isVorbisHeaderPacket(packets[1], 5) {
+		return errors.New("tag: unsupported Ogg Vorbis header layout; comment and setup headers must end together on a page boundary")
+	}
+	lastHeaderPage := headerPages[len(headerPages)-1]
+	if _, continued := lastHeaderPage.packets(); continued {
+		return errors.New("tag: unsupported Ogg Vorbis header layout; audio data starts mid-packet on the last header page")
+	}
+
+	audioStart, err := rw.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	orig := newMetadataVorbis()
+	if err := orig.readVorbisComment(bytes.NewReader(packets[0][7:])); err != nil {
+		return err
+	}
+
+	vc := newMetadataVorbis()
+	vc.c["vendor"] = []string{orig.first("vendor")}
+	for k, v := range tags {
+		vc.c[strings.ToLower(k)] = []string{v}
+	}
+	if pic != nil {
+		vc.pics = []*Picture{pic}
+	}
+
+	var commentPacket bytes.Buffer
+	commentPacket.WriteByte(3)
+	commentPacket.WriteString("vorbis")
+	if err := vc.Write(&commentPacket); err != nil {
+		return err
+	}
+
+	setupPacket := packets[1]
+
+	if _, err := rw.Seek(audioStart, io.SeekStart); err != nil {
+		return err
+	}
+	audio, err := io.ReadAll(rw)
+	if err != nil {
+		return err
+	}
+
+	newHeaderPages := layoutOggPackets(idPage.serial, idPage.sequence+1, [][]byte{commentPacket.Bytes(), setupPacket})
+
+	if _, err := rw.Seek(oggPageLen(idPage), io.SeekStart); err != nil {
+		return err
+	}
+	for _, p := range newHeaderPages {
+		if err := writeOggPage(rw, p.headerType, p.granulePos, p.serial, p.sequence, p.segTable, p.payload); err != nil {
+			return err
+		}
+	}
+
+	seqDelta := int64(len(newHeaderPages)) - int64(len(headerPages))
+	if seqDelta != 0 {
+		buf := audio
+		for len(buf) > 0 {
+			if len(buf) < oggPageHeaderLen {
+				return errors.New("tag: truncated Ogg page trailing audio data")
+			}
+			nSeg := int(buf[26])
+			pageLen := oggPageHeaderLen + nSeg
+			for _, s := range buf[27 : 27+nSeg] {
+				pageLen += int(s)
+			}
+			page := make([]byte, pageLen)
+			copy(page, buf[:pageLen])
+
+			seq := uint32(getUintLittleEndian(page[18:22])) + uint32(seqDelta)
+			page[18] = byte(seq)
+			page[19] = byte(seq >> 8)
+			page[20] = byte(seq >> 16)
+			page[21] = byte(seq >> 24)
+			page[22], page[23], page[24], page[25] = 0, 0, 0, 0
+			crc := oggCRC32(page)
+			page[22] = byte(crc)
+			page[23] = byte(crc >> 8)
+			page[24] = byte(crc >> 16)
+			page[25] = byte(crc >> 24)
+
+			if _, err := rw.Write(page); err != nil {
+				return err
+			}
+			buf = buf[pageLen:]
+		}
+	} else if _, err := rw.Write(audio); err != nil {
+		return err
+	}
+
+	return truncateToCurrent(rw)
+}
+
+// truncateToCurrent truncates rw to its current seek offset, shrinking the
+// file when the rewritten header pages end up smaller than the ones they
+// replaced. rw need not support truncation (e.g. a plain io.ReadWriteSeeker
+// backed by something other than a file); in that case this is a no-op and
+// callers backed by an os.File should pass one to avoid leaving stale bytes
+// past the new end of file.
+func truncateToCurrent(rw io.ReadWriteSeeker) error {
+	t, ok := rw.(interface{ Truncate(int64) error })
+	if !ok {
+		return nil
+	}
+	end, err := rw.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return t.Truncate(end)
+}
+
+// oggPageLen returns the on-disk length of p as read by readOggPage.
+func oggPageLen(p *oggPage) int64 {
+	return oggPageHeaderLen + int64(len(p.segTable)) + int64(len(p.payload))
+}
+
+// oggMaxSegments is the largest segment table a single Ogg page can carry:
+// the page_segments field is one byte.
+const oggMaxSegments = 255
+
+// oggMaxFinalPagePacketLen is the longest packet (or packet remainder)
+// that can end on a single page: lacePacket always appends a terminating
+// segment (possibly zero) after its run of 255s, so the last full run
+// must leave room for it within oggMaxSegments segments.
+const oggMaxFinalPagePacketLen = (oggMaxSegments - 1) * 255
+
+// oggContinuationChunkLen is how much of an oversized packet a single
+// continuation page carries: oggMaxSegments segments all laced at 255,
+// with no terminating segment, since the packet isn't finished yet.
+const oggContinuationChunkLen = oggMaxSegments * 255
+
+// splitPacketPages lays a single packet out across as many pages as its
+// length requires, splitting it with continuation pages (full runs of
+// 255-valued lacing, no terminator) once it's too long to fit on one
+// page's 255-entry segment table. Every resulting segment table fits
+// within oggMaxSegments.
+func splitPacketPages(pkt []byte) [][2][]byte {
+	var chunks [][2][]byte
+	remaining := pkt
+	for len(remaining) > oggMaxFinalPagePacketLen {
+		// A continuation chunk's segment table is all 255s, so its length
+		// must be a multiple of 255; cap it at oggContinuationChunkLen,
+		// but shrink it when what's left wouldn't otherwise leave enough
+		// room for the terminator on the final page.
+		n := oggContinuationChunkLen
+		if n > len(remaining) {
+			n = (len(remaining) / 255) * 255
+		}
+		chunks = append(chunks, [2][]byte{bytes.Repeat([]byte{255}, n/255), remaining[:n]})
+		remaining = remaining[n:]
+	}
+	chunks = append(chunks, [2][]byte{lacePacket(len(remaining)), remaining})
+	return chunks
+}
+
+// layoutOggPackets lays packets out as sequential Ogg pages, one packet
+// per page (even when a packet would fit alongside the next), starting at
+// sequence on serial. This is simpler than repacking to minimize page
+// count and matches a framing many encoders already produce for header
+// packets. Packets over oggMaxFinalPagePacketLen bytes (as an embedded
+// Picture comment can be) are split across continuation pages rather than
+// silently overflowing a single page's segment table.
+func layoutOggPackets(serial, sequence uint32, packets [][]byte) []*oggPage {
+	var pages []*oggPage
+	for _, pkt := range packets {
+		for i, chunk := range splitPacketPages(pkt) {
+			headerType := byte(0)
+			if i > 0 {
+				headerType = oggContinuedPacket
+			}
+			pages = append(pages, &oggPage{
+				headerType: headerType,
+				granulePos: 0,
+				serial:     serial,
+				sequence:   sequence,
+				segTable:   chunk[0],
+				payload:    chunk[1],
+			})
+			sequence++
+		}
+	}
+	return pages
+}
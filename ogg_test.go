@@ -0,0 +1,185 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"testing"
+)
+
+// buildTestOggFile builds a minimal Ogg Vorbis stream (an identification
+// header page, the comment and setup headers laid out one per page by
+// layoutOggPackets, and a trailing audio page) with comments as the
+// Vorbis comment fields.
+func buildTestOggFile(comments map[string]string) []byte {
+	var buf bytes.Buffer
+
+	idPkt := append([]byte{1}, []byte("vorbis")...)
+	idPkt = append(idPkt, make([]byte, 30)...)
+	writeOggPage(&buf, oggFirstPage, 0, 1, 0, lacePacket(len(idPkt)), idPkt)
+
+	vc := newMetadataVorbis()
+	vc.c["vendor"] = []string{"test"}
+	for k, v := range comments {
+		vc.c[k] = []string{v}
+	}
+	var commentPkt bytes.Buffer
+	commentPkt.WriteByte(3)
+	commentPkt.WriteString("vorbis")
+	vc.Write(&commentPkt)
+
+	setupPkt := append([]byte{5}, []byte("vorbis")...)
+	setupPkt = append(setupPkt, make([]byte, 20)...)
+
+	pages := layoutOggPackets(1, 1, [][]byte{commentPkt.Bytes(), setupPkt})
+	for _, p := range pages {
+		writeOggPage(&buf, p.headerType, p.granulePos, p.serial, p.sequence, p.segTable, p.payload)
+	}
+
+	audioPkt := bytes.Repeat([]byte{0xCD}, 128)
+	writeOggPage(&buf, oggLastPage, 1000, 1, uint32(len(pages)+1), lacePacket(len(audioPkt)), audioPkt)
+
+	return buf.Bytes()
+}
+
+// readBackOggComment re-parses the comment header packet written by
+// WriteOggVorbisTags, mirroring the read side of readOggPage/packets that
+// WriteOggVorbisTags itself uses.
+func readBackOggComment(t *testing.T, data []byte) *metadataVorbis {
+	t.Helper()
+	r := bytes.NewReader(data)
+
+	idPage, err := readOggPage(r)
+	if err != nil {
+		t.Fatalf("readOggPage(id): %v", err)
+	}
+	if _, continued := idPage.packets(); continued {
+		t.Fatal("identification page unexpectedly continues")
+	}
+
+	var packets [][]byte
+	var partial []byte
+	for len(packets) < 2 {
+		p, err := readOggPage(r)
+		if err != nil {
+			t.Fatalf("readOggPage(header): %v", err)
+		}
+		pkts, continued := p.packets()
+		for i, pkt := range pkts {
+			if i == 0 && len(partial) > 0 {
+				partial = append(partial, pkt...)
+				pkt = partial
+				partial = nil
+			}
+			if continued && i == len(pkts)-1 {
+				partial = pkt
+				continue
+			}
+			packets = append(packets, pkt)
+		}
+	}
+
+	vc := newMetadataVorbis()
+	if err := vc.readVorbisComment(bytes.NewReader(packets[0][7:])); err != nil {
+		t.Fatalf("readVorbisComment: %v", err)
+	}
+	return vc
+}
+
+func TestWriteOggVorbisTagsRoundTrip(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestOggFile(map[string]string{"title": "old"})}
+
+	if err := WriteOggVorbisTags(rw, map[string]string{"title": "new title", "artist": "band"}, nil); err != nil {
+		t.Fatalf("WriteOggVorbisTags: %v", err)
+	}
+
+	vc := readBackOggComment(t, rw.buf)
+	if got := vc.first("title"); got != "new title" {
+		t.Errorf("title = %q, want %q", got, "new title")
+	}
+	if got := vc.first("artist"); got != "band" {
+		t.Errorf("artist = %q, want %q", got, "band")
+	}
+
+	if !bytes.Equal(rw.buf[len(rw.buf)-128:], bytes.Repeat([]byte{0xCD}, 128)) {
+		t.Fatal("audio page bytes were corrupted by the tag rewrite")
+	}
+}
+
+// TestWriteOggVorbisTagsWithPictureRoundTrip covers WriteOggVorbisTags
+// writing a Picture, which metadataVorbis.Write inlines into the comment
+// packet as a base64 METADATA_BLOCK_PICTURE field.
+func TestWriteOggVorbisTagsWithPictureRoundTrip(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestOggFile(map[string]string{"title": "old"})}
+
+	pic := &Picture{
+		MIMEType:    "image/jpeg",
+		Description: "cover",
+		Data:        bytes.Repeat([]byte{0xEE}, 4096),
+	}
+	if err := WriteOggVorbisTags(rw, map[string]string{"title": "new"}, pic); err != nil {
+		t.Fatalf("WriteOggVorbisTags: %v", err)
+	}
+
+	vc := readBackOggComment(t, rw.buf)
+	if got := vc.first("title"); got != "new" {
+		t.Errorf("title = %q, want %q", got, "new")
+	}
+	pics := vc.Pictures()
+	if len(pics) != 1 || !bytes.Equal(pics[0].Data, pic.Data) {
+		t.Fatalf("picture round-trip failed: got %d pics", len(pics))
+	}
+}
+
+// TestWriteOggVorbisTagsWithLargePictureRoundTrip covers a picture large
+// enough that its base64-inlined comment packet needs more than 255
+// lacing segments (the single-page limit lacePacket alone can express),
+// exercising layoutOggPackets'/writeOggPage's continuation-page splitting.
+func TestWriteOggVorbisTagsWithLargePictureRoundTrip(t *testing.T) {
+	rw := &seekBuffer{buf: buildTestOggFile(map[string]string{"title": "old"})}
+
+	pic := &Picture{
+		MIMEType: "image/jpeg",
+		Data:     bytes.Repeat([]byte{0xEE}, 200*1024), // typical embedded cover art size
+	}
+	if err := WriteOggVorbisTags(rw, map[string]string{"title": "new"}, pic); err != nil {
+		t.Fatalf("WriteOggVorbisTags: %v", err)
+	}
+
+	vc := readBackOggComment(t, rw.buf)
+	if got := vc.first("title"); got != "new" {
+		t.Fatalf("title = %q, want %q (stream corrupted)", got, "new")
+	}
+	pics := vc.Pictures()
+	if len(pics) != 1 || !bytes.Equal(pics[0].Data, pic.Data) {
+		t.Fatalf("picture round-trip failed: got %d pics", len(pics))
+	}
+
+	if !bytes.Equal(rw.buf[len(rw.buf)-128:], bytes.Repeat([]byte{0xCD}, 128)) {
+		t.Fatal("audio page bytes were corrupted by the tag rewrite")
+	}
+}
+
+func TestWriteOggVorbisTagsTruncatesOnShrink(t *testing.T) {
+	comments := make(map[string]string, 50)
+	for i := 0; i < 50; i++ {
+		comments[string(rune('a'+i%26))+string(rune('0'+i/26))] = string(bytes.Repeat([]byte("x"), 50+i))
+	}
+	orig := buildTestOggFile(comments)
+	rw := &seekBuffer{buf: append([]byte{}, orig...)}
+
+	if err := WriteOggVorbisTags(rw, map[string]string{"title": "x"}, nil); err != nil {
+		t.Fatalf("WriteOggVorbisTags: %v", err)
+	}
+
+	if len(rw.buf) >= len(orig) {
+		t.Fatalf("expected the file to shrink: orig=%d new=%d", len(orig), len(rw.buf))
+	}
+
+	vc := readBackOggComment(t, rw.buf)
+	if got := vc.first("title"); got != "x" {
+		t.Errorf("title = %q, want %q", got, "x")
+	}
+}
@@ -15,13 +15,51 @@ import (
 	"time"
 )
 
+// Vorbis comment field names, as defined by
+// https://www.xiph.org/vorbis/doc/v-comment.html and the Xiph wiki's
+// field name registry (https://wiki.xiph.org/Field_names). Field names
+// are case-insensitive; Values looks them up lower-cased, as stored by
+// readVorbisComment.
+const (
+	FieldTitle        = "title"
+	FieldVersion      = "version"
+	FieldAlbum        = "album"
+	FieldTrackNumber  = "tracknumber"
+	FieldArtist       = "artist"
+	FieldPerformer    = "performer"
+	FieldCopyright    = "copyright"
+	FieldLicense      = "license"
+	FieldOrganization = "organization"
+	FieldDescription  = "description"
+	FieldGenre        = "genre"
+	FieldDate         = "date"
+	FieldLocation     = "location"
+	FieldContact      = "contact"
+	FieldISRC         = "isrc"
+	FieldRemixer      = "remixer"
+	FieldConductor    = "conductor"
+	FieldEnsemble     = "ensemble"
+	FieldComposer     = "composer"
+	FieldDiscNumber   = "discnumber"
+	FieldLabel        = "label"
+
+	FieldReplayGainTrackGain = "replaygain_track_gain"
+	FieldReplayGainTrackPeak = "replaygain_track_peak"
+	FieldReplayGainAlbumGain = "replaygain_album_gain"
+	FieldReplayGainAlbumPeak = "replaygain_album_peak"
+
+	FieldMusicBrainzTrackID  = "musicbrainz_trackid"
+	FieldMusicBrainzAlbumID  = "musicbrainz_albumid"
+	FieldMusicBrainzArtistID = "musicbrainz_artistid"
+)
+
 func newMetadataVorbis() *metadataVorbis {
 	return &metadataVorbis{
 		sampleRate: 0,
 		channels: 0,
 		bitDepth: 0,
 		samples: 0,
-		c: make(map[string]string),
+		c: make(map[string][]string),
 	}
 }
 
@@ -32,8 +70,8 @@ type metadataVorbis struct {
 	bitDepth uint
 	samples uint64
 
-	c map[string]string // the vorbis comments
-	p *Picture
+	c    map[string][]string // the vorbis comments; the Vorbis spec allows repeated keys
+	pics []*Picture          // FLAC/Vorbis allow more than one picture block
 }
 
 func (m *metadataVorbis) readVorbisComment(r io.Reader) error {
@@ -46,7 +84,7 @@ func (m *metadataVorbis) readVorbisComment(r io.Reader) error {
 	if err != nil {
 		return err
 	}
-	m.c["vendor"] = vendor
+	m.c["vendor"] = []string{vendor}
 
 	commentsLen, err := readUint32LittleEndian(r)
 	if err != nil {
@@ -66,19 +104,20 @@ func (m *metadataVorbis) readVorbisComment(r io.Reader) error {
 		if err != nil {
 			return err
 		}
-		if _, ok := m.c[strings.ToLower(k)]; ok {
-			m.c[strings.ToLower(k)] = m.c[strings.ToLower(k)] + "\\\\" + v
-		} else {
-			m.c[strings.ToLower(k)] = v
+		k = strings.ToLower(k)
+
+		if k == "metadata_block_picture" {
+			data, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return err
+			}
+			if err := m.readPictureBlock(bytes.NewReader(data)); err != nil {
+				return err
+			}
+			continue
 		}
-	}
 
-	if b64data, ok := m.c["metadata_block_picture"]; ok {
-		data, err := base64.StdEncoding.DecodeString(b64data)
-		if err != nil {
-			return err
-		}
-		m.readPictureBlock(bytes.NewReader(data))
+		m.c[k] = append(m.c[k], v)
 	}
 
 	return nil
@@ -149,16 +188,111 @@ func (m *metadataVorbis) readPictureBlock(r io.Reader) error {
 		return err
 	}
 
-	m.p = &Picture{
+	m.pics = append(m.pics, &Picture{
 		Ext:         ext,
 		MIMEType:    mime,
 		Type:        pictureType,
 		Description: desc,
 		Data:        data,
+	})
+	return nil
+}
+
+// Write writes the vendor string, comment list and (if present) picture
+// block held by m in Vorbis comment format, the inverse of
+// readVorbisComment. It writes only the comment header payload; callers
+// are responsible for the surrounding container (a FLAC metadata block
+// header, or an Ogg page).
+func (m *metadataVorbis) Write(w io.Writer) error {
+	vendor := m.first("vendor")
+	if err := writeUint32LittleEndian(w, uint32(len(vendor))); err != nil {
+		return err
+	}
+	if err := writeString(w, vendor); err != nil {
+		return err
+	}
+
+	var comments []string
+	for k, vs := range m.c {
+		if k == "vendor" {
+			continue
+		}
+		for _, v := range vs {
+			comments = append(comments, strings.ToUpper(k)+"="+v)
+		}
+	}
+	for _, pic := range m.pics {
+		data, err := encodePictureBlock(pic)
+		if err != nil {
+			return err
+		}
+		comments = append(comments, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(data))
+	}
+
+	if err := writeUint32LittleEndian(w, uint32(len(comments))); err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := writeUint32LittleEndian(w, uint32(len(c))); err != nil {
+			return err
+		}
+		if err := writeString(w, c); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// encodePictureBlock encodes pic using the same field layout
+// readPictureBlock parses: type, MIME type, description, width, height,
+// color depth, colors used and the raw image data, all as big-endian
+// 32-bit lengths/values.
+func encodePictureBlock(pic *Picture) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := writeInt(&buf, 4, int(pictureTypeCode(pic.Type))); err != nil {
+		return nil, err
+	}
+	if err := writeUint32BigEndian(&buf, uint32(len(pic.MIMEType))); err != nil {
+		return nil, err
+	}
+	if err := writeString(&buf, pic.MIMEType); err != nil {
+		return nil, err
+	}
+	if err := writeUint32BigEndian(&buf, uint32(len(pic.Description))); err != nil {
+		return nil, err
+	}
+	if err := writeString(&buf, pic.Description); err != nil {
+		return nil, err
+	}
+	// width, height, color depth and colors used aren't tracked on Picture;
+	// FLAC and Vorbis readers treat these as informational only, so zero
+	// values (meaning "unknown") are always valid.
+	for i := 0; i < 4; i++ {
+		if err := writeUint32BigEndian(&buf, 0); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeUint32BigEndian(&buf, uint32(len(pic.Data))); err != nil {
+		return nil, err
+	}
+	if _, err := buf.Write(pic.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pictureTypeCode returns the FLAC/Vorbis picture block type code for t,
+// the inverse of the pictureTypes lookup table.
+func pictureTypeCode(t PictureType) byte {
+	for code, typ := range pictureTypes {
+		if typ == t {
+			return code
+		}
+	}
+	return 0
+}
+
 func parseComment(c string) (k, v string, err error) {
 	kv := strings.SplitN(c, "=", 2)
 	if len(kv) != 2 {
@@ -170,32 +304,32 @@ func parseComment(c string) (k, v string, err error) {
 	return
 }
 
-func (m *metadataVorbis) Format() Format {
-	return VORBIS
+// first returns the first value stored for key, or "" if key has none.
+// Most Metadata accessors only ever surface one value per field; Values
+// exposes the rest for fields the Vorbis spec allows to repeat.
+func (m *metadataVorbis) first(key string) string {
+	vs := m.c[key]
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[0]
 }
 
-func (m *metadataVorbis) Raw() map[string]interface{} {
-	raw := make(map[string]interface{}, len(m.c) + 4)
-	if m.sampleRate > 0 {
-		raw["_sampleRate"] = m.sampleRate
-	}
-	if m.samples > 0 {
-		raw["_samples"] = m.samples
-	}
-	if m.channels > 0 {
-		raw["_channels"] = m.channels
-	}
-	if m.bitDepth > 0 {
-		raw["_bitdepth"] = m.bitDepth
-	}
-	for k, v := range m.c {
-		raw[k] = v
-	}
-	return raw
+// Values returns every value stored under key (case-insensitive), in the
+// order they appeared in the file. The Vorbis comment spec permits a key
+// such as ARTIST or PERFORMER to occur more than once; Values returns the
+// occurrences beyond the first as well, while the typed accessors
+// (Artist, Composer, ...) return only the first.
+func (m *metadataVorbis) Values(key string) []string {
+	return m.c[strings.ToLower(key)]
+}
+
+func (m *metadataVorbis) Format() Format {
+	return VORBIS
 }
 
 func (m *metadataVorbis) Title() string {
-	return m.c["title"]
+	return m.first(FieldTitle)
 }
 
 func (m *metadataVorbis) Artist() string {
@@ -204,20 +338,20 @@ func (m *metadataVorbis) Artist() string {
 	// conductor, orchestra, soloists. In an audio book it would be the actor who
 	// did the reading. In popular music this is typically the same as the ARTIST
 	// and is omitted.
-	if m.c["performer"] != "" {
-		return m.c["performer"]
+	if p := m.first(FieldPerformer); p != "" {
+		return p
 	}
-	return m.c["artist"]
+	return m.first(FieldArtist)
 }
 
 func (m *metadataVorbis) Album() string {
-	return m.c["album"]
+	return m.first(FieldAlbum)
 }
 
 func (m *metadataVorbis) AlbumArtist() string {
 	// This field isn't actually included in the standard, though
 	// it is commonly assigned to albumartist.
-	return m.c["albumartist"]
+	return m.first("albumartist")
 }
 
 func (m *metadataVorbis) Composer() string {
@@ -225,25 +359,27 @@ func (m *metadataVorbis) Composer() string {
 	// The artist generally considered responsible for the work. In popular music
 	// this is usually the performing band or singer. For classical music it would
 	// be the composer. For an audio book it would be the author of the original text.
-	if m.c["composer"] != "" {
-		return m.c["composer"]
+	if c := m.first(FieldComposer); c != "" {
+		return c
 	}
-	if m.c["performer"] == "" {
+	if m.first(FieldPerformer) == "" {
 		return ""
 	}
-	return m.c["artist"]
+	return m.first(FieldArtist)
 }
 
 func (m *metadataVorbis) Genre() string {
-	return m.c["genre"]
+	return m.first(FieldGenre)
 }
 
 func (m *metadataVorbis) Year() int {
 	var dateFormat string
 
+	date := m.first(FieldDate)
+
 	// The date need to follow the international standard https://en.wikipedia.org/wiki/ISO_8601
 	// and obviously the VorbisComment standard https://wiki.xiph.org/VorbisComment#Date_and_time
-	switch len(m.c["date"]) {
+	switch len(date) {
 	case 0:
 		return 0
 	case 4:
@@ -254,37 +390,48 @@ func (m *metadataVorbis) Year() int {
 		dateFormat = "2006-01-02"
 	}
 
-	t, _ := time.Parse(dateFormat, m.c["date"])
+	t, _ := time.Parse(dateFormat, date)
 	return t.Year()
 }
 
 func (m *metadataVorbis) Track() (int, int) {
-	x, _ := strconv.Atoi(m.c["tracknumber"])
+	x, _ := strconv.Atoi(m.first(FieldTrackNumber))
 	// https://wiki.xiph.org/Field_names
-	n, _ := strconv.Atoi(m.c["tracktotal"])
+	n, _ := strconv.Atoi(m.first("tracktotal"))
 	return x, n
 }
 
 func (m *metadataVorbis) Disc() (int, int) {
 	// https://wiki.xiph.org/Field_names
-	x, _ := strconv.Atoi(m.c["discnumber"])
-	n, _ := strconv.Atoi(m.c["disctotal"])
+	x, _ := strconv.Atoi(m.first(FieldDiscNumber))
+	n, _ := strconv.Atoi(m.first("disctotal"))
 	return x, n
 }
 
 func (m *metadataVorbis) Lyrics() string {
-	return m.c["lyrics"]
+	return m.first("lyrics")
 }
 
 func (m *metadataVorbis) Comment() string {
-	if m.c["comment"] != "" {
-		return m.c["comment"]
+	if c := m.first("comment"); c != "" {
+		return c
 	}
-	return m.c["description"]
+	return m.first(FieldDescription)
 }
 
 func (m *metadataVorbis) Picture() *Picture {
-	return m.p
+	if len(m.pics) == 0 {
+		return nil
+	}
+	return m.pics[0]
+}
+
+// Pictures returns every picture block read from the file. FLAC and
+// Vorbis both allow more than one METADATA_BLOCK_PICTURE/PICTURE entry
+// (e.g. a front cover and a back cover); Picture only ever returns the
+// first.
+func (m *metadataVorbis) Pictures() []*Picture {
+	return m.pics
 }
 
 func (m *metadataVorbis) SampleRate() uint {
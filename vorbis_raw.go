@@ -0,0 +1,32 @@
+//go:build !legacyraw
+
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+// Raw returns the parsed tag data. Vorbis comment values are returned as
+// []string, since the Vorbis spec allows a key such as ARTIST or
+// PERFORMER to be repeated (see Values). Build with the legacyraw tag to
+// instead join repeated values into a single string, matching this
+// package's behavior before multi-value support was added.
+func (m *metadataVorbis) Raw() map[string]interface{} {
+	raw := make(map[string]interface{}, len(m.c)+4)
+	if m.sampleRate > 0 {
+		raw["_sampleRate"] = m.sampleRate
+	}
+	if m.samples > 0 {
+		raw["_samples"] = m.samples
+	}
+	if m.channels > 0 {
+		raw["_channels"] = m.channels
+	}
+	if m.bitDepth > 0 {
+		raw["_bitdepth"] = m.bitDepth
+	}
+	for k, v := range m.c {
+		raw[k] = v
+	}
+	return raw
+}
@@ -0,0 +1,33 @@
+//go:build legacyraw
+
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import "strings"
+
+// Raw returns the parsed tag data, joining repeated Vorbis comment values
+// with "\\\\" as this package did before multi-value support was added.
+// This build is kept for callers that still expect Raw's Vorbis comment
+// entries to be plain strings; new code should prefer Values.
+func (m *metadataVorbis) Raw() map[string]interface{} {
+	raw := make(map[string]interface{}, len(m.c)+4)
+	if m.sampleRate > 0 {
+		raw["_sampleRate"] = m.sampleRate
+	}
+	if m.samples > 0 {
+		raw["_samples"] = m.samples
+	}
+	if m.channels > 0 {
+		raw["_channels"] = m.channels
+	}
+	if m.bitDepth > 0 {
+		raw["_bitdepth"] = m.bitDepth
+	}
+	for k, vs := range m.c {
+		raw[k] = strings.Join(vs, "\\\\")
+	}
+	return raw
+}
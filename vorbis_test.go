@@ -0,0 +1,95 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// buildTestVorbisComment builds a Vorbis comment header payload (vendor
+// string plus comments, each already formatted as "KEY=value" or
+// "METADATA_BLOCK_PICTURE=<base64>"), the input readVorbisComment parses.
+func buildTestVorbisComment(vendor string, comments []string) []byte {
+	var buf bytes.Buffer
+	writeUint32LittleEndian(&buf, uint32(len(vendor)))
+	writeString(&buf, vendor)
+	writeUint32LittleEndian(&buf, uint32(len(comments)))
+	for _, c := range comments {
+		writeUint32LittleEndian(&buf, uint32(len(c)))
+		writeString(&buf, c)
+	}
+	return buf.Bytes()
+}
+
+// TestReadVorbisCommentMultiValueField covers a key the Vorbis spec
+// allows to repeat (ARTIST), which Values must return in file order while
+// the typed accessor (Artist) keeps returning only the first.
+func TestReadVorbisCommentMultiValueField(t *testing.T) {
+	m := newMetadataVorbis()
+	data := buildTestVorbisComment("test", []string{
+		"ARTIST=first artist",
+		"ARTIST=second artist",
+	})
+	if err := m.readVorbisComment(bytes.NewReader(data)); err != nil {
+		t.Fatalf("readVorbisComment: %v", err)
+	}
+
+	got := m.Values("artist")
+	want := []string{"first artist", "second artist"}
+	if len(got) != len(want) {
+		t.Fatalf("Values(\"artist\") = %v, want %v", got, want)
+	}
+	for i, v := range want {
+		if got[i] != v {
+			t.Errorf("Values(\"artist\")[%d] = %q, want %q", i, got[i], v)
+		}
+	}
+	if art := m.Artist(); art != "first artist" {
+		t.Errorf("Artist() = %q, want %q", art, "first artist")
+	}
+}
+
+// TestReadVorbisCommentMultiplePictures covers FLAC/Vorbis's support for
+// more than one embedded picture block (e.g. a front cover and a back
+// cover): Pictures must return all of them, in order, while Picture keeps
+// returning only the first.
+func TestReadVorbisCommentMultiplePictures(t *testing.T) {
+	pic1 := &Picture{MIMEType: "image/jpeg", Description: "front", Data: []byte{0x01, 0x02}}
+	pic2 := &Picture{MIMEType: "image/png", Description: "back", Data: []byte{0x03, 0x04, 0x05}}
+
+	data1, err := encodePictureBlock(pic1)
+	if err != nil {
+		t.Fatalf("encodePictureBlock(pic1): %v", err)
+	}
+	data2, err := encodePictureBlock(pic2)
+	if err != nil {
+		t.Fatalf("encodePictureBlock(pic2): %v", err)
+	}
+
+	m := newMetadataVorbis()
+	data := buildTestVorbisComment("test", []string{
+		"METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(data1),
+		"METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(data2),
+	})
+	if err := m.readVorbisComment(bytes.NewReader(data)); err != nil {
+		t.Fatalf("readVorbisComment: %v", err)
+	}
+
+	pics := m.Pictures()
+	if len(pics) != 2 {
+		t.Fatalf("Pictures() returned %d pictures, want 2", len(pics))
+	}
+	if pics[0].Description != "front" || !bytes.Equal(pics[0].Data, pic1.Data) {
+		t.Errorf("Pictures()[0] = %+v, want description %q data %v", pics[0], "front", pic1.Data)
+	}
+	if pics[1].Description != "back" || !bytes.Equal(pics[1].Data, pic2.Data) {
+		t.Errorf("Pictures()[1] = %+v, want description %q data %v", pics[1], "back", pic2.Data)
+	}
+	if got := m.Picture(); got != pics[0] {
+		t.Errorf("Picture() didn't return the first of multiple pictures")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2015, David Howden
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tag
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// writeUint32LittleEndian writes v to w as 4 little-endian bytes, the
+// counterpart of readUint32LittleEndian.
+func writeUint32LittleEndian(w io.Writer, v uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	_, err := w.Write(b)
+	return err
+}
+
+// writeUint32BigEndian writes v to w as 4 big-endian bytes, the counterpart
+// of readUint32BigEndian.
+func writeUint32BigEndian(w io.Writer, v uint32) error {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	_, err := w.Write(b)
+	return err
+}
+
+// writeString writes s to w verbatim, the counterpart of readString(r, n)
+// where n is already known by the caller.
+func writeString(w io.Writer, s string) error {
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// writeInt writes v to w as an n-byte big-endian unsigned integer, the
+// counterpart of readInt(r, n).
+func writeInt(w io.Writer, n int, v int) error {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	_, err := w.Write(b)
+	return err
+}